@@ -6,6 +6,8 @@ import (
 	"bytes"
 	"errors"
 	"net"
+	"sync"
+	"time"
 
 	radix "github.com/mediocregopher/radix.v2"
 )
@@ -69,21 +71,101 @@ func (mm *maybeMessage) Unmarshal(fn func(interface{}) error) error {
 	return err
 }
 
+// DialFunc is used by a persistent SubConn (see NewPersistent) to establish
+// a new radix.Conn whenever the previous one is lost.
+type DialFunc func() (radix.Conn, error)
+
+// Event describes a notable occurrence in the reconnect loop of a
+// persistent SubConn. Err is the error which triggered a reconnect, or the
+// error from a failed reconnect attempt; it is nil for a successful
+// reconnect.
+type Event struct {
+	Err error
+}
+
+// PersistentOpts are used to tune the behavior of a persistent SubConn, as
+// returned by NewPersistent. The zero value is valid and results in the
+// defaults described below being used.
+type PersistentOpts struct {
+	// MinReconnectBackoff and MaxReconnectBackoff bound the exponential
+	// backoff used between reconnect attempts. They default to 50
+	// milliseconds and 5 seconds, respectively.
+	MinReconnectBackoff time.Duration
+	MaxReconnectBackoff time.Duration
+
+	// HealthInterval is how long the SubConn will wait without seeing any
+	// traffic on the connection before sending a PING to make sure it's
+	// still alive. A zero value disables health checking.
+	HealthInterval time.Duration
+
+	// PingTimeout is how long the SubConn will wait, after HealthInterval
+	// has elapsed with no traffic, for a response to its PING before
+	// considering the connection dead and reconnecting. Defaults to 5
+	// seconds.
+	PingTimeout time.Duration
+}
+
+func (o PersistentOpts) withDefaults() PersistentOpts {
+	if o.MinReconnectBackoff <= 0 {
+		o.MinReconnectBackoff = 50 * time.Millisecond
+	}
+	if o.MaxReconnectBackoff <= 0 {
+		o.MaxReconnectBackoff = 5 * time.Second
+	}
+	if o.PingTimeout <= 0 {
+		o.PingTimeout = 5 * time.Second
+	}
+	return o
+}
+
 // SubConn wraps a radix.Conn in order to provide a channel to which messages
 // from subscribed channels will be written.
 type SubConn struct {
+	// connMutex guards c, cmdDoneCh and lastErr, all of which are replaced
+	// or set by doReconnect/persistentSpin from the persistentSpin
+	// goroutine while doCmd, Err and friends may be reading them
+	// concurrently from arbitrary caller goroutines.
+	connMutex sync.RWMutex
 	c         radix.Conn
 	lastErr   error
 	cmdDoneCh chan chan bool
 	closeCh   chan bool
 
+	// cmdMutex serializes doCmd calls and resubscribe. Without it, the
+	// health check's background Ping (see persistentSpin) could race with
+	// a caller's Subscribe/Unsubscribe/PSubscribe/PUnsubscribe: two doCmd
+	// calls overlapping would mean two sends into the capacity-1
+	// cmdDoneCh, and since only one goroutine (readSpin/persistentSpin)
+	// ever drains it, the second send would block until the first
+	// command's reply arrived. resubscribe also takes it for the same
+	// reason: it encodes/decodes on sc.c directly during reconnect,
+	// outside the normal cmdDoneCh flow, so it must exclude doCmd or the
+	// two could interleave on the wire and steal each other's acks.
+	cmdMutex sync.Mutex
+
+	// dial and opts are only set for SubConns created via NewPersistent.
+	dial DialFunc
+	opts PersistentOpts
+
+	subMutex sync.Mutex
+	channels map[string]bool
+	patterns map[string]bool
+
 	// Ch is the channel to which all publish messages for subscribed channels
 	// will be written. It should be being read from at all times in a separate
 	// go-routine from the one making subscribe/unsubscribe calls on the Client.
 	//
 	// This channel will be closed if the Close method is called or an error is
-	// encountered. The Err method can be used to retrieve the last error.
+	// encountered. The Err method can be used to retrieve the last error. A
+	// SubConn created via NewPersistent never closes Ch on error; it
+	// reconnects instead and reports the error on Events.
 	Ch <-chan Message
+
+	// Events carries notifications about the reconnect loop of a SubConn
+	// created via NewPersistent. It is nil for SubConns created via New. The
+	// channel is buffered and events are dropped if it isn't being read, so
+	// reading from it is optional.
+	Events <-chan Event
 }
 
 // New returns an initizlied SubConn. Check the docs on the Ch field for how to
@@ -94,12 +176,83 @@ func New(c radix.Conn) *SubConn {
 		c:         radix.TimeoutOk(c),
 		cmdDoneCh: make(chan chan bool, 1),
 		closeCh:   make(chan bool),
+		channels:  map[string]bool{},
+		patterns:  map[string]bool{},
 		Ch:        ch,
 	}
 	go sc.readSpin(ch)
 	return sc
 }
 
+// NewPersistent is like New, but instead of closing Ch when the underlying
+// connection errors out, it transparently redials using dial (with a capped
+// exponential backoff, see PersistentOpts) and re-issues SUBSCRIBE/PSUBSCRIBE
+// for whatever channels/patterns were active at the time of the error, so
+// the caller never has to notice the disconnect or re-subscribe itself.
+//
+// opts may be omitted to use the defaults. Reconnect attempts and their
+// outcome are reported on the returned SubConn's Events field.
+func NewPersistent(dial DialFunc, opts ...PersistentOpts) (*SubConn, error) {
+	var o PersistentOpts
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	o = o.withDefaults()
+
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Message)
+	events := make(chan Event, 16)
+	sc := &SubConn{
+		c:         radix.TimeoutOk(c),
+		cmdDoneCh: make(chan chan bool, 1),
+		closeCh:   make(chan bool),
+		dial:      dial,
+		opts:      o,
+		channels:  map[string]bool{},
+		patterns:  map[string]bool{},
+		Ch:        ch,
+		Events:    events,
+	}
+	go sc.persistentSpin(ch, events)
+	return sc, nil
+}
+
+// getConn returns the current underlying connection. It's safe to call from
+// any goroutine, since a persistent SubConn's doReconnect replaces c from the
+// persistentSpin goroutine.
+func (sc *SubConn) getConn() radix.Conn {
+	sc.connMutex.RLock()
+	defer sc.connMutex.RUnlock()
+	return sc.c
+}
+
+// getCmdDoneCh returns the current cmdDoneCh. It's safe to call from any
+// goroutine; see getConn.
+func (sc *SubConn) getCmdDoneCh() chan chan bool {
+	sc.connMutex.RLock()
+	defer sc.connMutex.RUnlock()
+	return sc.cmdDoneCh
+}
+
+// setLastErr sets lastErr. It's safe to call from any goroutine; see getConn.
+func (sc *SubConn) setLastErr(err error) {
+	sc.connMutex.Lock()
+	defer sc.connMutex.Unlock()
+	sc.lastErr = err
+}
+
+// getLastErr returns lastErr. It's safe to call from any goroutine; see
+// getConn.
+func (sc *SubConn) getLastErr() error {
+	sc.connMutex.RLock()
+	defer sc.connMutex.RUnlock()
+	return sc.lastErr
+}
+
 func (sc *SubConn) readSpin(ch chan Message) {
 	defer close(ch)
 	defer close(sc.closeCh)
@@ -116,10 +269,10 @@ func (sc *SubConn) readSpin(ch chan Message) {
 		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
 			continue
 		} else if err != nil {
-			sc.lastErr = err
+			sc.setLastErr(err)
 			return
 		} else if !mm.ok {
-			(<-sc.cmdDoneCh) <- true
+			(<-sc.getCmdDoneCh()) <- true
 			continue
 		}
 
@@ -127,10 +280,186 @@ func (sc *SubConn) readSpin(ch chan Message) {
 	}
 }
 
-// Err returns the error which caused the SubConn to close, if any. This should
-// only be called after Ch has been closed.
+// persistentSpin is the equivalent of readSpin for a SubConn created via
+// NewPersistent: rather than returning (and closing ch) on error, it hands
+// off to doReconnect and keeps going.
+func (sc *SubConn) persistentSpin(ch chan Message, events chan Event) {
+	defer close(ch)
+	defer close(sc.closeCh)
+	defer sc.c.Close()
+
+	backoff := sc.opts.MinReconnectBackoff
+	lastMsg := time.Now()
+	pinging := false
+
+	for {
+		select {
+		case <-sc.closeCh:
+			return
+		default:
+		}
+
+		var mm maybeMessage
+		err := sc.c.Decode(&mm)
+		now := time.Now()
+
+		if err == nil {
+			lastMsg = now
+			pinging = false
+			backoff = sc.opts.MinReconnectBackoff
+			if !mm.ok {
+				(<-sc.getCmdDoneCh()) <- true
+				continue
+			}
+			ch <- mm.Message
+			continue
+		}
+
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			if sc.opts.HealthInterval <= 0 {
+				continue
+			}
+			since := now.Sub(lastMsg)
+			if pinging && since > sc.opts.HealthInterval+sc.opts.PingTimeout {
+				err = errors.New("pubsub: health check ping timed out")
+			} else {
+				if !pinging && since > sc.opts.HealthInterval {
+					pinging = true
+					go sc.Ping()
+				}
+				continue
+			}
+		}
+
+		sc.setLastErr(err)
+		if !sc.doReconnect(events, &backoff) {
+			return
+		}
+		pinging = false
+		lastMsg = time.Now()
+	}
+}
+
+// doReconnect closes the current (broken) connection and redials using
+// sc.dial, backing off between attempts, until either a new connection is
+// established and resubscribed or Close is called. It returns false in the
+// latter case.
+//
+// It also replaces cmdDoneCh with a fresh one, closing any doneCh left
+// behind in the old one: a doCmd call (e.g. the health check's Ping) may
+// still be blocked waiting on it, and since its command's reply will never
+// arrive on the connection being replaced, that entry would otherwise sit in
+// cmdDoneCh forever and wedge every future doCmd call. cmdMutex (held by
+// every doCmd call for its whole push+encode+wait) guarantees only one
+// doneCh can ever be outstanding, so draining at most one here is enough.
+func (sc *SubConn) doReconnect(events chan Event, backoff *time.Duration) bool {
+	sc.getConn().Close()
+	sendEvent(events, Event{Err: sc.getLastErr()})
+
+	sc.connMutex.Lock()
+	staleCmdDoneCh := sc.cmdDoneCh
+	sc.cmdDoneCh = make(chan chan bool, 1)
+	sc.connMutex.Unlock()
+	select {
+	case doneCh := <-staleCmdDoneCh:
+		close(doneCh)
+	default:
+	}
+
+	for {
+		select {
+		case <-sc.closeCh:
+			return false
+		case <-time.After(*backoff):
+		}
+
+		c, err := sc.dial()
+		if err == nil {
+			sc.connMutex.Lock()
+			sc.c = radix.TimeoutOk(c)
+			sc.connMutex.Unlock()
+			if err = sc.resubscribe(); err != nil {
+				sc.getConn().Close()
+			}
+		}
+
+		if err != nil {
+			sendEvent(events, Event{Err: err})
+			*backoff *= 2
+			if *backoff > sc.opts.MaxReconnectBackoff {
+				*backoff = sc.opts.MaxReconnectBackoff
+			}
+			continue
+		}
+
+		sendEvent(events, Event{})
+		*backoff = sc.opts.MinReconnectBackoff
+		return true
+	}
+}
+
+// resubscribe re-issues SUBSCRIBE/PSUBSCRIBE on sc.c for the channels/
+// patterns currently tracked, draining their acks itself since it runs
+// outside of the normal doCmd/cmdDoneCh flow. It holds cmdMutex for its
+// whole duration so it can't interleave on the wire with a concurrent
+// doCmd call (e.g. a caller's Subscribe/Unsubscribe/Ping racing a
+// reconnect) — without that exclusion, the two could encode/decode on
+// sc.c in an interleaved order, and resubscribeCmd's decode loop could
+// consume the other call's ack, leaving its doCmd blocked on <-doneCh
+// forever.
+func (sc *SubConn) resubscribe() error {
+	sc.cmdMutex.Lock()
+	defer sc.cmdMutex.Unlock()
+
+	sc.subMutex.Lock()
+	channels := make([]string, 0, len(sc.channels))
+	for ch := range sc.channels {
+		channels = append(channels, ch)
+	}
+	patterns := make([]string, 0, len(sc.patterns))
+	for p := range sc.patterns {
+		patterns = append(patterns, p)
+	}
+	sc.subMutex.Unlock()
+
+	if err := sc.resubscribeCmd("SUBSCRIBE", channels); err != nil {
+		return err
+	}
+	return sc.resubscribeCmd("PSUBSCRIBE", patterns)
+}
+
+func (sc *SubConn) resubscribeCmd(cmd string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	conn := sc.getConn()
+	if err := conn.Encode(radix.CmdNoKey(cmd, names)); err != nil {
+		return err
+	}
+	for range names {
+		var mm maybeMessage
+		if err := conn.Decode(&mm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sendEvent(events chan Event, e Event) {
+	select {
+	case events <- e:
+	default:
+	}
+}
+
+// Err returns the most recent error encountered by the SubConn. For a
+// SubConn created via New, this is the error which caused Ch to close, and
+// Err should only be called after that happens. For a SubConn created via
+// NewPersistent, Ch never closes, so Err may be called at any time and
+// instead reflects whatever error most recently triggered a reconnect (see
+// Events for a stream of these as they happen).
 func (sc *SubConn) Err() error {
-	return sc.lastErr
+	return sc.getLastErr()
 }
 
 // Close will clean up the resources taken by this SubConn and then call Close
@@ -142,21 +471,25 @@ func (sc *SubConn) Close() error {
 
 // Subscribe runs a Redis "SUBSCRIBE" command with the provided channels
 func (sc *SubConn) Subscribe(channels ...string) {
+	sc.trackSub(sc.channels, channels)
 	sc.doCmd("SUBSCRIBE", channels...)
 }
 
 // PSubscribe runs a Redis "PSUBSCRIBE" command with the provided patterns
 func (sc *SubConn) PSubscribe(patterns ...string) {
+	sc.trackSub(sc.patterns, patterns)
 	sc.doCmd("PSUBSCRIBE", patterns...)
 }
 
 // Unsubscribe runs a Redis "UNSSUBSCRIBE" command with the provided channels
 func (sc *SubConn) Unsubscribe(channels ...string) {
+	sc.trackUnsub(sc.channels, channels)
 	sc.doCmd("UNSUBSCRIBE", channels...)
 }
 
 // PUnsubscribe runs a Redis "PUNSSUBSCRIBE" command with the provided patterns
 func (sc *SubConn) PUnsubscribe(patterns ...string) {
+	sc.trackUnsub(sc.patterns, patterns)
 	sc.doCmd("PUNSUBSCRIBE", patterns...)
 }
 
@@ -167,8 +500,30 @@ func (sc *SubConn) Ping() {
 }
 
 func (sc *SubConn) doCmd(cmd string, args ...string) {
+	// cmdMutex excludes every other doCmd call (including the health
+	// check's own Ping), so at most one doneCh is ever outstanding in
+	// cmdDoneCh at a time; see the field comment.
+	sc.cmdMutex.Lock()
+	defer sc.cmdMutex.Unlock()
+
 	doneCh := make(chan bool)
-	sc.cmdDoneCh <- doneCh
-	sc.c.Encode(radix.CmdNoKey(cmd, args))
+	sc.getCmdDoneCh() <- doneCh
+	sc.getConn().Encode(radix.CmdNoKey(cmd, args))
 	<-doneCh
 }
+
+func (sc *SubConn) trackSub(set map[string]bool, names []string) {
+	sc.subMutex.Lock()
+	for _, n := range names {
+		set[n] = true
+	}
+	sc.subMutex.Unlock()
+}
+
+func (sc *SubConn) trackUnsub(set map[string]bool, names []string) {
+	sc.subMutex.Lock()
+	for _, n := range names {
+		delete(set, n)
+	}
+	sc.subMutex.Unlock()
+}
@@ -0,0 +1,110 @@
+package pubsub
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	radix "github.com/mediocregopher/radix.v2"
+
+	"testing"
+)
+
+type fakeAddr struct{}
+
+func (fakeAddr) Network() string { return "tcp" }
+func (fakeAddr) String() string  { return "fake:0" }
+
+// fakeReply is one canned Decode result for a fakeConn.
+type fakeReply struct {
+	fields [][]byte // the array reply; one Message.Unmarshal rejects is an ack
+	err    error
+}
+
+// fakeConn is a minimal radix.Conn double driven by a queue of canned Decode
+// replies, so SubConn's reconnect/health-check logic can be exercised
+// without a real redis server.
+type fakeConn struct {
+	replies []fakeReply
+	closed  bool
+}
+
+func newFakeConn(replies ...fakeReply) *fakeConn {
+	return &fakeConn{replies: replies}
+}
+
+func (c *fakeConn) Encode(v interface{}) error { return nil }
+
+func (c *fakeConn) Decode(v interface{}) error {
+	if len(c.replies) == 0 {
+		return errors.New("fakeConn: out of canned replies")
+	}
+	r := c.replies[0]
+	c.replies = c.replies[1:]
+	if r.err != nil {
+		return r.err
+	}
+
+	mm, ok := v.(*maybeMessage)
+	if !ok {
+		return errors.New("fakeConn: unexpected Decode target")
+	}
+	return mm.Unmarshal(func(dst interface{}) error {
+		bbp, ok := dst.(*[][]byte)
+		if !ok {
+			return errors.New("fakeConn: unexpected Unmarshal target")
+		}
+		*bbp = r.fields
+		return nil
+	})
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *fakeConn) RemoteAddr() net.Addr { return fakeAddr{} }
+
+// TestDoReconnectDrainsStaleCmdDoneCh covers the case where doReconnect is
+// triggered while a doCmd call (e.g. the health-check's Ping) is still
+// blocked waiting on an entry it pushed into cmdDoneCh. Without draining
+// that entry, it and every cmdDoneCh pushed after it would block forever,
+// since the command whose ack it's waiting for will never arrive on the
+// connection being replaced.
+func TestDoReconnectDrainsStaleCmdDoneCh(t *testing.T) {
+	next := newFakeConn(fakeReply{fields: [][]byte{[]byte("psubscribe"), []byte("foo.*"), []byte("1")}})
+
+	sc := &SubConn{
+		c:         newFakeConn(),
+		cmdDoneCh: make(chan chan bool, 1),
+		closeCh:   make(chan bool),
+		dial: func() (radix.Conn, error) {
+			return next, nil
+		},
+		opts:     PersistentOpts{}.withDefaults(),
+		channels: map[string]bool{},
+		patterns: map[string]bool{"foo.*": true},
+	}
+
+	stalePing := make(chan bool)
+	sc.cmdDoneCh <- stalePing
+
+	events := make(chan Event, 16)
+	backoff := sc.opts.MinReconnectBackoff
+	if !sc.doReconnect(events, &backoff) {
+		t.Fatal("doReconnect reported Close before a reconnect was attempted")
+	}
+
+	select {
+	case <-stalePing:
+	case <-time.After(time.Second):
+		t.Fatal("stale doneCh was never closed by doReconnect; a blocked doCmd caller would hang forever")
+	}
+
+	select {
+	case sc.cmdDoneCh <- make(chan bool):
+	default:
+		t.Fatal("cmdDoneCh wasn't replaced and is still wedged by the stale entry")
+	}
+}
@@ -44,53 +44,155 @@
 package sentinel
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	radix "github.com/mediocregopher/radix.v2"
+	"github.com/mediocregopher/radix.v2/pubsub"
 )
 
+// Client is a sentinel-backed connection to one or more named redis masters,
+// as returned by NewClient/NewClientWithOptions. It automatically fails over
+// each of them to a new master and refreshes its sentinel address list in the
+// background.
+type Client = sentinelClient
+
+// switchMasterChannels are the standard Sentinel pub/sub event channels
+// watchSwitchMaster subscribes to in order to react to failovers (and
+// membership changes) as soon as Sentinel announces them, instead of
+// waiting for the next periodicRefresh poll.
+var switchMasterChannels = []string{
+	"+switch-master",
+	"+slave",
+	"+sentinel",
+	"+odown",
+	"-odown",
+}
+
+// masterState holds everything tracked about a single monitored master.
+type masterState struct {
+	pool  radix.Pool
+	pAddr string
+}
+
 type sentinelClient struct {
-	// we read lock when calling methods on p, and normal lock when swapping the
-	// value of p, pAddr, or modifying addrs
+	// we read lock when calling methods on a master's pool, and normal lock
+	// when swapping a master's pool/pAddr, adding/removing masters from
+	// masters, or modifying addrs
 	sync.RWMutex
-	p     radix.Pool
-	pAddr string
-	addrs []string // the known sentinel addresses
+	masters map[string]*masterState // keyed by master name
+	addrs   []string                // the known sentinel addresses
+
+	dfn radix.DialFunc // the function used to dial sentinel instances
+	pfn radix.PoolFunc
 
-	name string
-	dfn  radix.DialFunc // the function used to dial sentinel instances
-	pfn  radix.PoolFunc
+	sub     *pubsub.SubConn // non-nil once watchSwitchMaster has been started
+	stopCh  chan struct{}
+	stopped sync.Once
+
+	// onReplicaAnnounce, if non-nil, is invoked by watchSwitchMaster with
+	// the "ip:port" from a "+slave" notification. It's set by
+	// NewFailoverCluster to FailoverClusterClient.addReplica, since a
+	// "+slave" announces a data-node replica, not a sentinel instance, and
+	// a plain sentinelClient has no replica set of its own to grow.
+	onReplicaAnnounce func(addr string)
 }
 
-func (sc *sentinelClient) Do(a radix.Action) error {
+func (sc *sentinelClient) master(name string) (*masterState, error) {
+	sc.RLock()
+	defer sc.RUnlock()
+	ms, ok := sc.masters[name]
+	if !ok {
+		return nil, fmt.Errorf("sentinel: unknown master %q", name)
+	}
+	return ms, nil
+}
+
+// Do performs a against the named master's pool.
+func (sc *sentinelClient) Do(name string, a radix.Action) error {
+	ms, err := sc.master(name)
+	if err != nil {
+		return err
+	}
 	sc.RLock()
 	defer sc.RUnlock()
-	return sc.p.Do(a)
+	return ms.pool.Do(a)
 }
 
 func (sc *sentinelClient) Close() error {
+	sc.stopped.Do(func() {
+		if sc.stopCh != nil {
+			close(sc.stopCh)
+		}
+	})
+
+	sc.Lock()
+	sub := sc.sub
+	sc.Unlock()
+	if sub != nil {
+		sub.Close()
+	}
+
 	sc.RLock()
 	defer sc.RUnlock()
-	// TODO probably need to stop the sentinel conn
-	return sc.p.Close()
+	var firstErr error
+	for _, ms := range sc.masters {
+		if err := ms.pool.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
-func (sc *sentinelClient) Get() (radix.PoolConn, error) {
+// Get returns a connection from the named master's pool.
+func (sc *sentinelClient) Get(name string) (radix.PoolConn, error) {
+	ms, err := sc.master(name)
+	if err != nil {
+		return nil, err
+	}
 	sc.RLock()
 	defer sc.RUnlock()
-	return sc.p.Get()
+	return ms.pool.Get()
 }
 
-// given a connection to a sentinel, ensures that the pool currently being held
-// agrees with what the sentinel thinks it should be
-func (sc *sentinelClient) ensureMaster(conn radix.Conn) error {
-	sc.Lock()
-	lastAddr := sc.pAddr
-	sc.Unlock()
+// GetMaster is an alias of Get.
+func (sc *sentinelClient) GetMaster(name string) (radix.PoolConn, error) {
+	return sc.Get(name)
+}
+
+// PutMaster returns a connection acquired via GetMaster/Get to its pool.
+func (sc *sentinelClient) PutMaster(name string, conn radix.PoolConn) {
+	ms, err := sc.master(name)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	sc.RLock()
+	defer sc.RUnlock()
+	ms.pool.Put(conn)
+}
+
+// given a connection to a sentinel, ensures that the pool currently held for
+// name agrees with what the sentinel thinks it should be
+func (sc *sentinelClient) ensureMaster(conn radix.Conn, name string) error {
+	ms, err := sc.master(name)
+	if err != nil {
+		return err
+	}
+
+	sc.RLock()
+	lastAddr := ms.pAddr
+	sc.RUnlock()
 
 	var m map[string]string
-	err := radix.CmdNoKey("SENTINEL", "MASTER", sc.name).Into(&m).Run(conn)
+	err = radix.CmdNoKey("SENTINEL", "MASTER", name).Into(&m).Run(conn)
 	if err != nil {
 		return err
 	} else if m["ip"] == "" || m["port"] == "" {
@@ -107,22 +209,44 @@ func (sc *sentinelClient) ensureMaster(conn radix.Conn) error {
 	}
 
 	sc.Lock()
-	if sc.p != nil {
-		sc.p.Close()
+	if ms.pool != nil {
+		ms.pool.Close()
 	}
-	sc.p = newPool
-	sc.pAddr = newAddr
+	ms.pool = newPool
+	ms.pAddr = newAddr
 	sc.Unlock()
 
 	return nil
 }
 
+// ensureAllMasters calls ensureMaster for every master this client tracks,
+// fanning the refresh out across all of them from the one shared sentinel
+// connection.
+func (sc *sentinelClient) ensureAllMasters(conn radix.Conn) error {
+	sc.RLock()
+	names := make([]string, 0, len(sc.masters))
+	for name := range sc.masters {
+		names = append(names, name)
+	}
+	sc.RUnlock()
+
+	var firstErr error
+	for _, name := range names {
+		if err := sc.ensureMaster(conn, name); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 // annoyingly the SENTINEL SENTINELS <name> command doesn't return _this_
-// sentinel instance, only the others it knows about for that master
-func (sc *sentinelClient) ensureSentinelAddrs(conn radix.Conn) error {
+// sentinel instance, only the others it knows about for that master. name
+// can be any one of the masters this client tracks, since they're all
+// monitored by the same set of sentinels.
+func (sc *sentinelClient) ensureSentinelAddrs(conn radix.Conn, name string) error {
 	addrs := []string{conn.RemoteAddr().String()}
 	var mm []map[string]string
-	err := radix.CmdNoKey("SENTINEL", "SENTINELS", sc.name).Into(&mm).Run(conn)
+	err := radix.CmdNoKey("SENTINEL", "SENTINELS", name).Into(&mm).Run(conn)
 	if err != nil {
 		return err
 	}
@@ -136,3 +260,716 @@ func (sc *sentinelClient) ensureSentinelAddrs(conn radix.Conn) error {
 	sc.Unlock()
 	return nil
 }
+
+// withSentinelConn dials one of the known sentinel addresses and passes the
+// connection to fn, closing it afterwards.
+func (sc *sentinelClient) withSentinelConn(fn func(radix.Conn) error) error {
+	sc.RLock()
+	addrs := append([]string(nil), sc.addrs...)
+	sc.RUnlock()
+
+	if len(addrs) == 0 {
+		return errors.New("sentinel: no known sentinel addresses")
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := sc.dfn("tcp", addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		lastErr = fn(conn)
+		conn.Close()
+		if lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+// dialSentinel dials the first of the known sentinel addresses that
+// succeeds, returning the connection to the caller to keep open. Unlike
+// withSentinelConn, it doesn't close the connection itself, since it's used
+// by watchSwitchMaster as the DialFunc for a persistent pubsub.SubConn that
+// needs to hold the connection open for the life of the subscription.
+func (sc *sentinelClient) dialSentinel() (radix.Conn, error) {
+	sc.RLock()
+	addrs := append([]string(nil), sc.addrs...)
+	sc.RUnlock()
+
+	if len(addrs) == 0 {
+		return nil, errors.New("sentinel: no known sentinel addresses")
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := sc.dfn("tcp", addr)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// periodicRefresh calls ensureAllMasters and ensureSentinelAddrs on the
+// given interval, acting as a safety net for any +switch-master or +sentinel
+// notification missed by watchSwitchMaster, e.g. while its connection is
+// itself reconnecting. It doesn't refresh replicas. It runs until Close is
+// called.
+func (sc *sentinelClient) periodicRefresh(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-sc.stopCh:
+			return
+		case <-t.C:
+			sc.withSentinelConn(func(conn radix.Conn) error {
+				if err := sc.ensureAllMasters(conn); err != nil {
+					return err
+				}
+
+				sc.RLock()
+				var name string
+				for n := range sc.masters {
+					name = n
+					break
+				}
+				sc.RUnlock()
+
+				return sc.ensureSentinelAddrs(conn, name)
+			})
+		}
+	}
+}
+
+// watchSwitchMaster maintains a persistent pubsub.SubConn against one of the
+// known sentinel addresses and PSUBSCRIBEs to switchMasterChannels. It reacts
+// to a "+switch-master" for any master this client tracks by immediately
+// swapping that master's pool to the new address, without waiting for the
+// next periodicRefresh, uses "+sentinel" announcements to grow sc.addrs, and
+// forwards "+slave" announcements to onReplicaAnnounce if one is set. If the
+// underlying connection drops, the reconnect dials through sc.addrs until
+// one succeeds.
+func (sc *sentinelClient) watchSwitchMaster() error {
+	sub, err := pubsub.NewPersistent(sc.dialSentinel)
+	if err != nil {
+		return err
+	}
+	sub.PSubscribe(switchMasterChannels...)
+
+	sc.Lock()
+	sc.sub = sub
+	sc.Unlock()
+
+	go func() {
+		for msg := range sub.Ch {
+			switch msg.Channel {
+			case "+switch-master":
+				sc.handleSwitchMaster(string(msg.Message))
+			case "+sentinel":
+				sc.mergeSentinelAddr(string(msg.Message))
+			case "+slave":
+				sc.handleSlaveAnnounce(string(msg.Message))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// handleSwitchMaster parses a "+switch-master <name> <old-ip> <old-port>
+// <new-ip> <new-port>" payload and, if name is one this client tracks, swaps
+// that master's pool to the new address.
+func (sc *sentinelClient) handleSwitchMaster(payload string) {
+	fields := strings.Fields(payload)
+	if len(fields) != 5 {
+		return
+	}
+	name, newAddr := fields[0], fields[3]+":"+fields[4]
+
+	ms, err := sc.master(name)
+	if err != nil {
+		return
+	}
+
+	sc.RLock()
+	unchanged := newAddr == ms.pAddr
+	sc.RUnlock()
+	if unchanged {
+		return
+	}
+
+	newPool, err := sc.pfn("tcp", newAddr)
+	if err != nil {
+		return
+	}
+
+	sc.Lock()
+	if ms.pool != nil {
+		ms.pool.Close()
+	}
+	ms.pool = newPool
+	ms.pAddr = newAddr
+	sc.Unlock()
+}
+
+// announcedAddr extracts the "ip:port" from a "+slave"/"+sentinel" payload
+// of the form "<name> <ip> <port> @ <master-name> <master-ip> <master-port>".
+func announcedAddr(payload string) (string, bool) {
+	fields := strings.Fields(payload)
+	if len(fields) < 3 {
+		return "", false
+	}
+	return fields[1] + ":" + fields[2], true
+}
+
+// mergeSentinelAddr parses a "+sentinel" payload and adds the reported
+// ip:port to sc.addrs if it isn't already known.
+func (sc *sentinelClient) mergeSentinelAddr(payload string) {
+	addr, ok := announcedAddr(payload)
+	if !ok {
+		return
+	}
+
+	sc.Lock()
+	defer sc.Unlock()
+	for _, a := range sc.addrs {
+		if a == addr {
+			return
+		}
+	}
+	sc.addrs = append(sc.addrs, addr)
+}
+
+// handleSlaveAnnounce parses a "+slave" payload and forwards the reported
+// ip:port to onReplicaAnnounce, if one is set. A "+slave" announces a
+// data-node replica, not a sentinel instance, so (unlike "+sentinel") it
+// must not be merged into sc.addrs: that list is only for sentinels, and a
+// replica address picked up there would get PSUBSCRIBEd to as if it were
+// one, quietly defeating fast failover detection.
+func (sc *sentinelClient) handleSlaveAnnounce(payload string) {
+	addr, ok := announcedAddr(payload)
+	if !ok {
+		return
+	}
+	if sc.onReplicaAnnounce != nil {
+		sc.onReplicaAnnounce(addr)
+	}
+}
+
+// ReadRoutePolicy determines how a FailoverClusterClient picks which replica
+// pool to use for a read-only Action passed to DoRead.
+type ReadRoutePolicy int
+
+const (
+	// RouteRandomly picks a uniformly random healthy replica for each read.
+	RouteRandomly ReadRoutePolicy = iota
+
+	// RouteByLatency picks the replica with the lowest EWMA round-trip time,
+	// as measured by periodic PINGs.
+	RouteByLatency
+)
+
+// replicaEWMAAlpha weights how quickly a replicaPool's ewma reacts to each
+// new ping sample.
+const replicaEWMAAlpha = 0.2
+
+type replicaPool struct {
+	pool radix.Pool
+	ewma float64 // milliseconds; meaningless unless healthy is true
+
+	// healthy is true once a ping has succeeded, and false initially and
+	// after any failed ping. pickReplica excludes unhealthy replicas from
+	// RouteByLatency selection, so a replica that's never been pinged yet
+	// or is currently unreachable can't look like the fastest candidate
+	// just because its zero-value ewma beats every measured RTT.
+	healthy bool
+}
+
+// FailoverClusterClient is a sentinel client which, in addition to pooling
+// connections to the master as sentinelClient does, also pools connections
+// to the master's replicas and can route read-only Actions to them via
+// DoRead. It mirrors the FailoverClusterClient capability offered by the
+// go-redis failover client.
+type FailoverClusterClient struct {
+	*sentinelClient
+
+	name   string // the single master this client tracks
+	policy ReadRoutePolicy
+
+	// guarded by the RWMutex embedded in sentinelClient
+	replicas map[string]*replicaPool
+}
+
+// NewFailoverCluster is like NewClientWithOptions, except o must name
+// exactly one master, and the returned FailoverClusterClient also maintains
+// pools for every one of that master's replicas and can route reads to them
+// according to policy.
+func NewFailoverCluster(o SentinelOptions, policy ReadRoutePolicy) (*FailoverClusterClient, error) {
+	if len(o.Names) != 1 {
+		return nil, errors.New("sentinel: FailoverClusterClient requires exactly one master name")
+	}
+
+	sc, err := newSentinelClient(o)
+	if err != nil {
+		return nil, err
+	}
+
+	fc := &FailoverClusterClient{
+		sentinelClient: sc,
+		name:           o.Names[0],
+		policy:         policy,
+		replicas:       map[string]*replicaPool{},
+	}
+	sc.onReplicaAnnounce = fc.addReplica
+
+	if err := fc.ensureReplicas(); err != nil {
+		return nil, err
+	} else if err := sc.watchSwitchMaster(); err != nil {
+		return nil, err
+	}
+
+	go sc.periodicRefresh(30 * time.Second)
+	go fc.periodicReplicaRefresh(30 * time.Second)
+	if policy == RouteByLatency {
+		go fc.periodicLatencyCheck(5 * time.Second)
+	}
+
+	return fc, nil
+}
+
+// Do performs a against fc's master pool.
+func (fc *FailoverClusterClient) Do(a radix.Action) error {
+	return fc.sentinelClient.Do(fc.name, a)
+}
+
+// Get returns a connection from fc's master pool.
+func (fc *FailoverClusterClient) Get() (radix.PoolConn, error) {
+	return fc.sentinelClient.Get(fc.name)
+}
+
+// DoRead performs a, which should be a read-only Action, against a replica
+// pool chosen according to fc.policy. If no replicas are currently known it
+// falls back to the master pool, same as Do.
+func (fc *FailoverClusterClient) DoRead(a radix.Action) error {
+	if pool := fc.pickReplica(); pool != nil {
+		return pool.Do(a)
+	}
+	return fc.Do(a)
+}
+
+func (fc *FailoverClusterClient) pickReplica() radix.Pool {
+	fc.RLock()
+	defer fc.RUnlock()
+	if len(fc.replicas) == 0 {
+		return nil
+	}
+
+	if fc.policy == RouteByLatency {
+		var best *replicaPool
+		for _, rp := range fc.replicas {
+			if !rp.healthy {
+				continue
+			}
+			if best == nil || rp.ewma < best.ewma {
+				best = rp
+			}
+		}
+		if best == nil {
+			return nil
+		}
+		return best.pool
+	}
+
+	pools := make([]radix.Pool, 0, len(fc.replicas))
+	for _, rp := range fc.replicas {
+		pools = append(pools, rp.pool)
+	}
+	return pools[rand.Intn(len(pools))]
+}
+
+// replicaAddrs extracts the set of healthy replica addresses ("ip:port")
+// from a SENTINEL REPLICAS/SLAVES reply, skipping entries missing an
+// address or flagged "down".
+func replicaAddrs(mm []map[string]string) map[string]bool {
+	addrs := make(map[string]bool, len(mm))
+	for _, m := range mm {
+		if m["ip"] == "" || m["port"] == "" || strings.Contains(m["flags"], "down") {
+			continue
+		}
+		addrs[m["ip"]+":"+m["port"]] = true
+	}
+	return addrs
+}
+
+// addReplica opens a pool for addr and adds it to fc.replicas if addr isn't
+// already tracked. It's set as sc.onReplicaAnnounce so a "+slave"
+// notification from watchSwitchMaster is reflected immediately, without
+// waiting for the next periodicReplicaRefresh; removal of stale replicas is
+// still left to ensureReplicas.
+func (fc *FailoverClusterClient) addReplica(addr string) {
+	fc.Lock()
+	defer fc.Unlock()
+	if _, ok := fc.replicas[addr]; ok {
+		return
+	}
+	pool, err := fc.pfn("tcp", addr)
+	if err != nil {
+		return
+	}
+	fc.replicas[addr] = &replicaPool{pool: pool}
+}
+
+// ensureReplicas refreshes fc.replicas from SENTINEL REPLICAS (falling back
+// to the older SENTINEL SLAVES on error), opening pools for newly seen
+// replicas and closing/removing ones which are gone or reported down.
+func (fc *FailoverClusterClient) ensureReplicas() error {
+	return fc.withSentinelConn(func(conn radix.Conn) error {
+		var mm []map[string]string
+		err := radix.CmdNoKey("SENTINEL", "REPLICAS", fc.name).Into(&mm).Run(conn)
+		if err != nil {
+			err = radix.CmdNoKey("SENTINEL", "SLAVES", fc.name).Into(&mm).Run(conn)
+		}
+		if err != nil {
+			return err
+		}
+
+		addrs := replicaAddrs(mm)
+
+		fc.Lock()
+		defer fc.Unlock()
+
+		for addr := range addrs {
+			if _, ok := fc.replicas[addr]; ok {
+				continue
+			}
+			pool, err := fc.pfn("tcp", addr)
+			if err != nil {
+				continue
+			}
+			fc.replicas[addr] = &replicaPool{pool: pool}
+		}
+
+		for addr, rp := range fc.replicas {
+			if !addrs[addr] {
+				rp.pool.Close()
+				delete(fc.replicas, addr)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (fc *FailoverClusterClient) periodicReplicaRefresh(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-fc.stopCh:
+			return
+		case <-t.C:
+			fc.ensureReplicas()
+		}
+	}
+}
+
+func (fc *FailoverClusterClient) periodicLatencyCheck(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-fc.stopCh:
+			return
+		case <-t.C:
+			fc.pingReplicas()
+		}
+	}
+}
+
+func (fc *FailoverClusterClient) pingReplicas() {
+	fc.RLock()
+	rps := make([]*replicaPool, 0, len(fc.replicas))
+	for _, rp := range fc.replicas {
+		rps = append(rps, rp)
+	}
+	fc.RUnlock()
+
+	for _, rp := range rps {
+		start := time.Now()
+		if err := rp.pool.Do(radix.CmdNoKey("PING")); err != nil {
+			fc.Lock()
+			rp.healthy = false
+			fc.Unlock()
+			continue
+		}
+		rtt := float64(time.Since(start)) / float64(time.Millisecond)
+
+		fc.Lock()
+		if !rp.healthy {
+			rp.ewma = rtt
+		} else {
+			rp.ewma = replicaEWMAAlpha*rtt + (1-replicaEWMAAlpha)*rp.ewma
+		}
+		rp.healthy = true
+		fc.Unlock()
+	}
+}
+
+// Close stops the background refresh loops, closes the replica pools, and
+// closes the master pool.
+func (fc *FailoverClusterClient) Close() error {
+	fc.Lock()
+	for addr, rp := range fc.replicas {
+		rp.pool.Close()
+		delete(fc.replicas, addr)
+	}
+	fc.Unlock()
+	return fc.sentinelClient.Close()
+}
+
+// SentinelOptions configures a Client or FailoverClusterClient constructed
+// via NewClientWithOptions/NewFailoverCluster. Addr and Names must be set;
+// every other field left at its zero value means no auth/TLS and the
+// package's default pool size and retry backoff.
+type SentinelOptions struct {
+	// Network and Addr are used to dial the initial sentinel instance; the
+	// rest are then discovered via SENTINEL SENTINELS.
+	Network string
+	Addr    string
+
+	// Names are the names of the monitored masters, as configured in the
+	// sentinels. NewFailoverCluster requires exactly one.
+	Names []string
+
+	// PoolSize is the size of the connection pool kept open to each master
+	// (and, for a FailoverClusterClient, to each replica). Defaults to 10.
+	PoolSize int
+
+	// SentinelPassword authenticates to the sentinel instances themselves,
+	// via AUTH, if they were configured with requirepass.
+	SentinelPassword string
+	// SentinelTLSConfig, if non-nil, is used for connections to the
+	// sentinel instances.
+	SentinelTLSConfig *tls.Config
+
+	// Username/Password authenticate to the data nodes (the master and any
+	// replicas) via AUTH.
+	Username string
+	Password string
+	// DB selects the database on the data nodes via SELECT.
+	DB int
+	// TLSConfig, if non-nil, is used for connections to the data nodes.
+	TLSConfig *tls.Config
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// MinRetryBackoff/MaxRetryBackoff bound the backoff used between
+	// attempts to redial a sentinel or data node. Default to 50ms/5s.
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+
+	// Dialer, if non-nil, replaces (&net.Dialer{Timeout: DialTimeout}).Dial
+	// for every connection this client makes, sentinel and data node alike.
+	Dialer func(network, addr string) (net.Conn, error)
+
+	// OnConnect, if non-nil, is called on every new data node connection
+	// after AUTH/SELECT, so callers can run e.g. CLIENT SETNAME.
+	OnConnect func(radix.Conn) error
+}
+
+func (o SentinelOptions) withDefaults() SentinelOptions {
+	if o.PoolSize <= 0 {
+		o.PoolSize = 10
+	}
+	if o.MinRetryBackoff <= 0 {
+		o.MinRetryBackoff = 50 * time.Millisecond
+	}
+	if o.MaxRetryBackoff <= 0 {
+		o.MaxRetryBackoff = 5 * time.Second
+	}
+	return o
+}
+
+func (o SentinelOptions) netDial(network, addr string) (net.Conn, error) {
+	dial := o.Dialer
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: o.DialTimeout}).Dial
+	}
+	return o.dialWithRetry(func() (net.Conn, error) { return dial(network, addr) })
+}
+
+// dialWithRetry calls dial, retrying with capped exponential backoff (see
+// MinRetryBackoff/MaxRetryBackoff) until it succeeds or the backoff would
+// exceed MaxRetryBackoff, at which point the last error is returned. This is
+// what actually redials a sentinel or data node through a transient outage;
+// every other caller of netDial (and so of sentinelDialFunc/dataDialFunc)
+// gets the retries for free.
+func (o SentinelOptions) dialWithRetry(dial func() (net.Conn, error)) (net.Conn, error) {
+	backoff := o.MinRetryBackoff
+	for {
+		nc, err := dial()
+		if err == nil {
+			return nc, nil
+		}
+		if backoff > o.MaxRetryBackoff {
+			return nil, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (o SentinelOptions) newConn(network, addr string, tlsConf *tls.Config) (radix.Conn, error) {
+	nc, err := o.netDial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConf != nil {
+		nc = tls.Client(nc, tlsConf)
+	}
+
+	conn, err := radix.NewConn(nc, radix.ConnReadTimeout(o.ReadTimeout), radix.ConnWriteTimeout(o.WriteTimeout))
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sentinelDialFunc returns a radix.DialFunc which dials a sentinel instance
+// using the sentinel-specific auth/TLS settings.
+func (o SentinelOptions) sentinelDialFunc() radix.DialFunc {
+	return func(network, addr string) (radix.Conn, error) {
+		conn, err := o.newConn(network, addr, o.SentinelTLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		if o.SentinelPassword != "" {
+			if err := radix.CmdNoKey("AUTH", o.SentinelPassword).Run(conn); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
+}
+
+// dataDialFunc returns a radix.DialFunc which dials a master/replica data
+// node, authenticating, selecting the configured DB, and running OnConnect.
+func (o SentinelOptions) dataDialFunc() radix.DialFunc {
+	return func(network, addr string) (radix.Conn, error) {
+		conn, err := o.newConn(network, addr, o.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		if o.Password != "" {
+			var err error
+			if o.Username != "" {
+				err = radix.CmdNoKey("AUTH", o.Username, o.Password).Run(conn)
+			} else {
+				err = radix.CmdNoKey("AUTH", o.Password).Run(conn)
+			}
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		if o.DB != 0 {
+			if err := radix.CmdNoKey("SELECT", strconv.Itoa(o.DB)).Run(conn); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		if o.OnConnect != nil {
+			if err := o.OnConnect(conn); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
+}
+
+// dataPoolFunc returns a radix.PoolFunc which pools connections produced by
+// dataDialFunc.
+func (o SentinelOptions) dataPoolFunc() radix.PoolFunc {
+	dial := o.dataDialFunc()
+	return func(network, addr string) (radix.Pool, error) {
+		return radix.NewPool(network, addr, o.PoolSize, radix.PoolConnFunc(dial))
+	}
+}
+
+// newSentinelClient builds a sentinelClient from o: dialing the initial
+// sentinel instance, discovering the other known sentinels, and locating
+// the current address of every master in o.Names. It doesn't start any
+// background goroutines, leaving that to the caller once any additional
+// setup (e.g. replica discovery) is done.
+func newSentinelClient(o SentinelOptions) (*sentinelClient, error) {
+	o = o.withDefaults()
+	if len(o.Names) == 0 {
+		return nil, errors.New("sentinel: at least one master name is required")
+	}
+
+	conn, err := o.sentinelDialFunc()(o.Network, o.Addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	sc := &sentinelClient{
+		addrs:   []string{o.Addr},
+		masters: make(map[string]*masterState, len(o.Names)),
+		dfn:     o.sentinelDialFunc(),
+		pfn:     o.dataPoolFunc(),
+		stopCh:  make(chan struct{}),
+	}
+	for _, name := range o.Names {
+		sc.masters[name] = &masterState{}
+	}
+
+	if err := sc.ensureSentinelAddrs(conn, o.Names[0]); err != nil {
+		return nil, err
+	}
+	if err := sc.ensureAllMasters(conn); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// NewClient creates a Client which uses sentinel to connect to and track the
+// master redis instance(s) with the given name(s). addr is the address of a
+// known sentinel instance; poolSize is the size of the connection pool kept
+// open to each master.
+//
+// NewClient is a thin, back-compat wrapper around NewClientWithOptions; use
+// that directly for auth, TLS, or other SentinelOptions.
+func NewClient(network, addr string, poolSize int, names ...string) (*Client, error) {
+	return NewClientWithOptions(SentinelOptions{
+		Network:  network,
+		Addr:     addr,
+		Names:    names,
+		PoolSize: poolSize,
+	})
+}
+
+// NewClientWithOptions is like NewClient, but takes in a SentinelOptions for
+// full control over auth, TLS, timeouts, retry backoff, and dialing.
+func NewClientWithOptions(o SentinelOptions) (*Client, error) {
+	sc, err := newSentinelClient(o)
+	if err != nil {
+		return nil, err
+	}
+	if err := sc.watchSwitchMaster(); err != nil {
+		return nil, err
+	}
+	go sc.periodicRefresh(30 * time.Second)
+
+	return sc, nil
+}
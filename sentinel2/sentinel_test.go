@@ -0,0 +1,227 @@
+package sentinel
+
+import (
+	"testing"
+
+	radix "github.com/mediocregopher/radix.v2"
+)
+
+// fakePool satisfies radix.Pool by embedding a nil one; it's only ever
+// stored and compared by identity in these tests, never called.
+type fakePool struct {
+	radix.Pool
+	id string
+}
+
+func newTestClient(addrs []string, masters map[string]*masterState) *sentinelClient {
+	return &sentinelClient{
+		addrs:   addrs,
+		masters: masters,
+		pfn: func(network, addr string) (radix.Pool, error) {
+			return &fakePool{id: addr}, nil
+		},
+	}
+}
+
+func TestHandleSwitchMaster(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    string
+		masterAddr string
+		wantAddr   string // expected ms.pAddr after handleSwitchMaster
+	}{
+		{
+			name:       "well formed switch for a tracked master",
+			payload:    "bucket0 10.0.0.1 6379 10.0.0.2 6380",
+			masterAddr: "10.0.0.1:6379",
+			wantAddr:   "10.0.0.2:6380",
+		},
+		{
+			name:       "unknown master is ignored",
+			payload:    "bucket9 10.0.0.1 6379 10.0.0.2 6380",
+			masterAddr: "10.0.0.1:6379",
+			wantAddr:   "10.0.0.1:6379",
+		},
+		{
+			name:       "unchanged address is a no-op",
+			payload:    "bucket0 10.0.0.1 6379 10.0.0.1 6379",
+			masterAddr: "10.0.0.1:6379",
+			wantAddr:   "10.0.0.1:6379",
+		},
+		{
+			name:       "malformed payload is ignored",
+			payload:    "bucket0 10.0.0.1 6379",
+			masterAddr: "10.0.0.1:6379",
+			wantAddr:   "10.0.0.1:6379",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			masters := map[string]*masterState{"bucket0": {pAddr: tt.masterAddr}}
+			sc := newTestClient(nil, masters)
+
+			sc.handleSwitchMaster(tt.payload)
+
+			if got := masters["bucket0"].pAddr; got != tt.wantAddr {
+				t.Fatalf("pAddr = %q, want %q", got, tt.wantAddr)
+			}
+		})
+	}
+}
+
+func TestPickReplicaByLatency(t *testing.T) {
+	fastest := &fakePool{id: "fastest"}
+	fc := &FailoverClusterClient{
+		sentinelClient: newTestClient(nil, nil),
+		policy:         RouteByLatency,
+		replicas: map[string]*replicaPool{
+			"10.0.0.1:6379": {pool: &fakePool{id: "slow"}, ewma: 12.5, healthy: true},
+			"10.0.0.2:6379": {pool: fastest, ewma: 3.1, healthy: true},
+			"10.0.0.3:6379": {pool: &fakePool{id: "mid"}, ewma: 7.0, healthy: true},
+		},
+	}
+
+	got := fc.pickReplica()
+	if got != radix.Pool(fastest) {
+		t.Fatalf("pickReplica() = %v, want the pool with the lowest ewma", got)
+	}
+}
+
+func TestPickReplicaSkipsUnhealthy(t *testing.T) {
+	fastest := &fakePool{id: "fastest"}
+	fc := &FailoverClusterClient{
+		sentinelClient: newTestClient(nil, nil),
+		policy:         RouteByLatency,
+		replicas: map[string]*replicaPool{
+			// Never pinged: zero-value ewma, which used to beat every
+			// measured RTT and always win regardless of health.
+			"10.0.0.1:6379": {pool: &fakePool{id: "unmeasured"}},
+			// Measured healthy once, but its latest ping failed.
+			"10.0.0.2:6379": {pool: &fakePool{id: "down"}, ewma: 1.0, healthy: false},
+			"10.0.0.3:6379": {pool: fastest, ewma: 9.0, healthy: true},
+		},
+	}
+
+	got := fc.pickReplica()
+	if got != radix.Pool(fastest) {
+		t.Fatalf("pickReplica() = %v, want the only healthy pool", got)
+	}
+}
+
+func TestPickReplicaAllUnhealthy(t *testing.T) {
+	fc := &FailoverClusterClient{
+		sentinelClient: newTestClient(nil, nil),
+		policy:         RouteByLatency,
+		replicas: map[string]*replicaPool{
+			"10.0.0.1:6379": {pool: &fakePool{id: "down"}, ewma: 1.0, healthy: false},
+		},
+	}
+
+	if got := fc.pickReplica(); got != nil {
+		t.Fatalf("pickReplica() = %v, want nil so DoRead falls back to the master", got)
+	}
+}
+
+func TestPickReplicaNoneKnown(t *testing.T) {
+	fc := &FailoverClusterClient{
+		sentinelClient: newTestClient(nil, nil),
+		policy:         RouteByLatency,
+		replicas:       map[string]*replicaPool{},
+	}
+
+	if got := fc.pickReplica(); got != nil {
+		t.Fatalf("pickReplica() = %v, want nil with no known replicas", got)
+	}
+}
+
+func TestReplicaAddrs(t *testing.T) {
+	tests := []struct {
+		name string
+		mm   []map[string]string
+		want map[string]bool
+	}{
+		{
+			name: "healthy replicas are kept",
+			mm: []map[string]string{
+				{"ip": "10.0.0.1", "port": "6379", "flags": "slave"},
+				{"ip": "10.0.0.2", "port": "6380", "flags": "slave"},
+			},
+			want: map[string]bool{"10.0.0.1:6379": true, "10.0.0.2:6380": true},
+		},
+		{
+			name: "down replicas are dropped",
+			mm: []map[string]string{
+				{"ip": "10.0.0.1", "port": "6379", "flags": "slave"},
+				{"ip": "10.0.0.2", "port": "6380", "flags": "slave,s_down"},
+			},
+			want: map[string]bool{"10.0.0.1:6379": true},
+		},
+		{
+			name: "entries missing ip/port are dropped",
+			mm: []map[string]string{
+				{"ip": "", "port": "6379", "flags": "slave"},
+				{"ip": "10.0.0.1", "port": "", "flags": "slave"},
+			},
+			want: map[string]bool{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := replicaAddrs(tt.mm)
+			if len(got) != len(tt.want) {
+				t.Fatalf("replicaAddrs() = %v, want %v", got, tt.want)
+			}
+			for addr := range tt.want {
+				if !got[addr] {
+					t.Fatalf("replicaAddrs() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeSentinelAddr(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		addrs     []string
+		wantAddrs []string
+	}{
+		{
+			name:      "new address is appended",
+			payload:   "bucket0 10.0.0.2 26379 @ bucket0 10.0.0.1 6379",
+			addrs:     []string{"10.0.0.1:26379"},
+			wantAddrs: []string{"10.0.0.1:26379", "10.0.0.2:26379"},
+		},
+		{
+			name:      "already known address is not duplicated",
+			payload:   "bucket0 10.0.0.1 26379 @ bucket0 10.0.0.1 6379",
+			addrs:     []string{"10.0.0.1:26379"},
+			wantAddrs: []string{"10.0.0.1:26379"},
+		},
+		{
+			name:      "malformed payload is ignored",
+			payload:   "bucket0 10.0.0.2",
+			addrs:     []string{"10.0.0.1:26379"},
+			wantAddrs: []string{"10.0.0.1:26379"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := newTestClient(append([]string(nil), tt.addrs...), nil)
+			sc.mergeSentinelAddr(tt.payload)
+
+			if len(sc.addrs) != len(tt.wantAddrs) {
+				t.Fatalf("addrs = %v, want %v", sc.addrs, tt.wantAddrs)
+			}
+			for i, a := range tt.wantAddrs {
+				if sc.addrs[i] != a {
+					t.Fatalf("addrs = %v, want %v", sc.addrs, tt.wantAddrs)
+				}
+			}
+		})
+	}
+}